@@ -0,0 +1,284 @@
+package tftp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/zenhack/go.tftp/packet"
+)
+
+// TransferError wraps a TFTP ERROR packet (rfc1350 §5) received from a
+// server during a Client transfer.
+type TransferError struct {
+	Code int
+	Msg  string
+}
+
+func (e *TransferError) Error() string {
+	return fmt.Sprintf("tftp: error %d: %s", e.Code, e.Msg)
+}
+
+// ClientOptions configures the transfers a Client performs. The zero value
+// requests no rfc2347 options, uses "octet" mode, DefaultTimeout, and
+// DefaultMaxRetries.
+type ClientOptions struct {
+	// BlockSize is the "blksize" option (rfc2348) to request; 0 means no
+	// negotiation, so transfers use packet.DefaultBlockSize.
+	BlockSize int
+
+	// Mode is the RRQ/WRQ transfer mode to request, e.g. "octet" or
+	// "netascii". Defaults to "octet".
+	Mode string
+
+	// Timeout is how long to wait for a reply before retransmitting.
+	// Defaults to DefaultTimeout.
+	Timeout time.Duration
+
+	// MaxRetries is how many retransmissions to attempt before giving up.
+	// Defaults to DefaultMaxRetries.
+	MaxRetries int
+}
+
+func (o ClientOptions) blockSize() int {
+	if o.BlockSize > 0 {
+		return o.BlockSize
+	}
+	return packet.DefaultBlockSize
+}
+
+func (o ClientOptions) mode() string {
+	if o.Mode != "" {
+		return o.Mode
+	}
+	return "octet"
+}
+
+func (o ClientOptions) timeout() time.Duration {
+	if o.Timeout > 0 {
+		return o.Timeout
+	}
+	return DefaultTimeout
+}
+
+func (o ClientOptions) maxRetries() int {
+	if o.MaxRetries > 0 {
+		return o.MaxRetries
+	}
+	return DefaultMaxRetries
+}
+
+// requestOptions builds the rfc2347 option set to send with the RRQ/WRQ; nil
+// if o doesn't ask for any (the zero value just uses the unnegotiated
+// rfc1350 defaults).
+func (o ClientOptions) requestOptions() map[string]string {
+	opts := map[string]string{}
+	if o.BlockSize > 0 {
+		opts["blksize"] = strconv.Itoa(o.BlockSize)
+	}
+	if seconds := int(o.Timeout / time.Second); seconds >= 1 {
+		// rfc2349 timeouts are whole seconds in [1, 255]; a sub-second
+		// o.Timeout just governs local retransmission and isn't negotiated.
+		opts["timeout"] = strconv.Itoa(seconds)
+	}
+	if len(opts) == 0 {
+		return nil
+	}
+	return opts
+}
+
+// Client speaks the client side of rfc1350 (plus rfc2347/2348/2349 option
+// negotiation) to a single TFTP server.
+type Client struct {
+	// Addr is the server's UDP address, e.g. "127.0.0.1:69".
+	Addr string
+
+	Options ClientOptions
+}
+
+// applyAcceptedOptions updates cn's transfer parameters to match the subset
+// of options the server's OACK confirmed, per rfc2347.
+func applyAcceptedOptions(cn *conn, accepted map[string]string) {
+	if v, ok := accepted["blksize"]; ok {
+		if n, err := strconv.Atoi(v); err == nil {
+			cn.blockSize = n
+		}
+	}
+	if v, ok := accepted["timeout"]; ok {
+		if n, err := strconv.Atoi(v); err == nil {
+			cn.timeout = time.Duration(n) * time.Second
+		}
+	}
+}
+
+// negotiate sends req (an *Rrq or *Wrq) to serverAddr over udpConn and waits
+// for the server's response, establishing its ephemeral TID as the
+// per-transfer peer address (mirroring the server's own per-transfer socket
+// in conn.go) and echoing back its byte order on every packet sent from the
+// returned *conn, per the doc-comment guidance in package packet. Unlike
+// conn.exchange, the first reply is accepted from any address, since the
+// server's TID isn't known until it replies (rfc1350 §4).
+func (c *Client) negotiate(udpConn *net.UDPConn, serverAddr *net.UDPAddr, req packet.Packet) (*conn, packet.Packet, error) {
+	opts := c.Options
+	timeout, maxRetries := opts.timeout(), opts.maxRetries()
+	buf := make([]byte, packet.MaxBlockSize+4)
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if err := sendPacket(udpConn, serverAddr, req, binary.LittleEndian); err != nil {
+			return nil, nil, err
+		}
+		deadline := time.Now().Add(timeout)
+		for {
+			udpConn.SetReadDeadline(deadline)
+			n, from, err := udpConn.ReadFromUDP(buf)
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					break // retransmit req, below
+				}
+				return nil, nil, err
+			}
+			// The block size isn't settled until the reply is seen, so a
+			// DATA reply is read in full, bounded only by the datagram.
+			pkt, order, perr := packet.ReadPacket(bytes.NewReader(buf[:n]), packet.MaxBlockSize)
+			if perr != nil {
+				continue
+			}
+			if e, ok := pkt.(*packet.Error); ok {
+				return nil, nil, &TransferError{Code: int(e.ErrorCode), Msg: e.ErrorMsg}
+			}
+			return &conn{
+				udpConn:    udpConn,
+				remoteAddr: from,
+				order:      order,
+				blockSize:  packet.DefaultBlockSize,
+				timeout:    timeout,
+				maxRetries: maxRetries,
+			}, pkt, nil
+		}
+	}
+	return nil, nil, errTimedOut
+}
+
+// getReadCloser is the io.ReadCloser Client.Get returns: it lazily reads
+// DATA packets via conn.Read, ACKing each as its payload is drained, and
+// closes the underlying per-transfer socket on Close.
+type getReadCloser struct {
+	*conn
+	r io.Reader
+}
+
+func (g *getReadCloser) Read(p []byte) (int, error) {
+	return g.r.Read(p)
+}
+
+func (g *getReadCloser) Close() error {
+	return g.conn.udpConn.Close()
+}
+
+// Get fetches remote from the server at c.Addr, returning an io.ReadCloser
+// that lazily reads DATA packets and sends ACKs as the caller reads. The
+// caller must Close it, even on error mid-read, to release the transfer's
+// UDP socket.
+func (c *Client) Get(remote string) (io.ReadCloser, error) {
+	opts := c.Options
+	mode, ok := lookupMode(opts.mode())
+	if !ok {
+		return nil, fmt.Errorf("tftp: unsupported transfer mode %q", opts.mode())
+	}
+
+	serverAddr, err := net.ResolveUDPAddr("udp", c.Addr)
+	if err != nil {
+		return nil, err
+	}
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+
+	rq := &packet.Rq{Filename: remote, Mode: opts.mode(), Options: opts.requestOptions()}
+	cn, reply, err := c.negotiate(udpConn, serverAddr, (*packet.Rrq)(rq))
+	if err != nil {
+		udpConn.Close()
+		return nil, err
+	}
+
+	switch p := reply.(type) {
+	case *packet.OAck:
+		applyAcceptedOptions(cn, p.Options)
+		// rfc2347: the client must explicitly ACK the OACK (as if it were
+		// DATA block 0) before the server sends block 1.
+		cn.pendingAck = &packet.Ack{BlockNum: 0}
+		if err := sendPacket(udpConn, cn.remoteAddr, cn.pendingAck, cn.order); err != nil {
+			udpConn.Close()
+			return nil, err
+		}
+	case *packet.Data:
+		// No OACK means none of the requested options were accepted, so
+		// the transfer uses the unnegotiated rfc1350 default block size.
+		cn.blockSize = packet.DefaultBlockSize
+		cn.blockNum = p.BlockNum
+		cn.rbuf = p.Data
+		cn.pendingAck = &packet.Ack{BlockNum: p.BlockNum}
+		if len(p.Data) < cn.blockSize {
+			// Final block: no further Read will call fillRead to carry this
+			// ack out via exchange's initial send, so send it now.
+			cn.eof = true
+			if err := sendPacket(udpConn, cn.remoteAddr, cn.pendingAck, cn.order); err != nil {
+				udpConn.Close()
+				return nil, err
+			}
+		}
+	default:
+		udpConn.Close()
+		return nil, fmt.Errorf("tftp: unexpected reply %T to RRQ", reply)
+	}
+
+	return &getReadCloser{conn: cn, r: mode.Decode(cn)}, nil
+}
+
+// Put uploads the contents of r to remote on the server at c.Addr, driving
+// the WRQ/DATA/ACK exchange until r is exhausted.
+func (c *Client) Put(remote string, r io.Reader) error {
+	opts := c.Options
+	mode, ok := lookupMode(opts.mode())
+	if !ok {
+		return fmt.Errorf("tftp: unsupported transfer mode %q", opts.mode())
+	}
+
+	serverAddr, err := net.ResolveUDPAddr("udp", c.Addr)
+	if err != nil {
+		return err
+	}
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		return err
+	}
+	defer udpConn.Close()
+
+	rq := &packet.Rq{Filename: remote, Mode: opts.mode(), Options: opts.requestOptions()}
+	cn, reply, err := c.negotiate(udpConn, serverAddr, (*packet.Wrq)(rq))
+	if err != nil {
+		return err
+	}
+
+	switch p := reply.(type) {
+	case *packet.OAck:
+		applyAcceptedOptions(cn, p.Options)
+	case *packet.Ack:
+		// No OACK means none of the requested options were accepted.
+		cn.blockSize = packet.DefaultBlockSize
+		if p.BlockNum != 0 {
+			return fmt.Errorf("tftp: unexpected ack %d to WRQ", p.BlockNum)
+		}
+	default:
+		return fmt.Errorf("tftp: unexpected reply %T to WRQ", reply)
+	}
+
+	if _, err := io.Copy(mode.Encode(cn), r); err != nil {
+		return err
+	}
+	return cn.finishWrite()
+}
@@ -0,0 +1,178 @@
+package tftp
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func startClientTestServer(t *testing.T, root string) string {
+	t.Helper()
+	addr := startTestServer(t, root)
+	return addr.String()
+}
+
+func TestClientGet(t *testing.T) {
+	dir := t.TempDir()
+	want := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog. "), 50)
+	if err := os.WriteFile(filepath.Join(dir, "foo.txt"), want, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &Client{
+		Addr:    startClientTestServer(t, dir),
+		Options: ClientOptions{Timeout: 200 * time.Millisecond, MaxRetries: 3},
+	}
+
+	rc, err := client.Get("foo.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got %d bytes, want %d", len(got), len(want))
+	}
+}
+
+func TestClientGetMissingFile(t *testing.T) {
+	dir := t.TempDir()
+	client := &Client{
+		Addr:    startClientTestServer(t, dir),
+		Options: ClientOptions{Timeout: 200 * time.Millisecond, MaxRetries: 3},
+	}
+
+	_, err := client.Get("nope.txt")
+	if err == nil {
+		t.Fatal("want error, got nil")
+	}
+	if _, ok := err.(*TransferError); !ok {
+		t.Fatalf("got %T, want *TransferError", err)
+	}
+}
+
+func TestClientPut(t *testing.T) {
+	dir := t.TempDir()
+	client := &Client{
+		Addr:    startClientTestServer(t, dir),
+		Options: ClientOptions{Timeout: 200 * time.Millisecond, MaxRetries: 3},
+	}
+
+	want := bytes.Repeat([]byte("uploaded via the client, block after block. "), 60)
+	if err := client.Put("uploaded.txt", bytes.NewReader(want)); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "uploaded.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got %d bytes on disk, want %d", len(got), len(want))
+	}
+}
+
+func TestClientGetPutRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	client := &Client{
+		Addr:    startClientTestServer(t, dir),
+		Options: ClientOptions{BlockSize: 1024, Timeout: 200 * time.Millisecond, MaxRetries: 3},
+	}
+
+	want := bytes.Repeat([]byte("round trip through blksize negotiation. "), 100)
+	if err := client.Put("roundtrip.txt", bytes.NewReader(want)); err != nil {
+		t.Fatal(err)
+	}
+
+	rc, err := client.Get("roundtrip.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got %d bytes, want %d", len(got), len(want))
+	}
+}
+
+func TestClientGetPutPartialOptionNegotiation(t *testing.T) {
+	dir := t.TempDir()
+	client := &Client{
+		Addr: startClientTestServer(t, dir),
+		// blksize is below packet.MinBlockSize, so the server rejects it
+		// while still accepting timeout; the OACK round-trips with only
+		// "timeout", and the transfer must fall back to the default block
+		// size rather than the client's rejected request.
+		Options: ClientOptions{BlockSize: 5, Timeout: 10 * time.Second, MaxRetries: 3},
+	}
+
+	want := bytes.Repeat([]byte("falls back to the default block size. "), 100)
+	if err := client.Put("partial.txt", bytes.NewReader(want)); err != nil {
+		t.Fatal(err)
+	}
+
+	rc, err := client.Get("partial.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got %d bytes, want %d", len(got), len(want))
+	}
+}
+
+func TestClientOptionsRequestOptions(t *testing.T) {
+	cases := []struct {
+		name string
+		opts ClientOptions
+		want map[string]string
+	}{
+		{"zero value", ClientOptions{}, nil},
+		{"sub-second timeout is local-only", ClientOptions{Timeout: 200 * time.Millisecond}, nil},
+		{"blksize", ClientOptions{BlockSize: 1024}, map[string]string{"blksize": "1024"}},
+		{"whole-second timeout", ClientOptions{Timeout: 10 * time.Second}, map[string]string{"timeout": "10"}},
+		{
+			"both",
+			ClientOptions{BlockSize: 1024, Timeout: 10 * time.Second},
+			map[string]string{"blksize": "1024", "timeout": "10"},
+		},
+	}
+	for _, c := range cases {
+		got := c.opts.requestOptions()
+		if len(got) != len(c.want) {
+			t.Fatalf("%s: got %v, want %v", c.name, got, c.want)
+		}
+		for k, v := range c.want {
+			if got[k] != v {
+				t.Fatalf("%s: got %v, want %v", c.name, got, c.want)
+			}
+		}
+	}
+}
+
+func TestApplyAcceptedOptions(t *testing.T) {
+	cn := &conn{blockSize: 512, timeout: DefaultTimeout}
+	applyAcceptedOptions(cn, map[string]string{"blksize": "1024", "timeout": "10"})
+	if cn.blockSize != 1024 {
+		t.Fatalf("got blockSize %d, want 1024", cn.blockSize)
+	}
+	if cn.timeout != 10*time.Second {
+		t.Fatalf("got timeout %v, want 10s", cn.timeout)
+	}
+}
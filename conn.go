@@ -0,0 +1,194 @@
+package tftp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/zenhack/go.tftp/packet"
+)
+
+// errTimedOut is returned by conn.exchange when maxRetries retransmissions
+// all go unanswered.
+var errTimedOut = errors.New("tftp: transfer timed out")
+
+// conn is the per-transfer TID socket: it implements ReadWriter over a
+// lock-step DATA/ACK exchange with the peer, handling retransmission on
+// timeout and rejecting packets from an unexpected remote address (rfc1350
+// §4, "unknown TID").
+type conn struct {
+	udpConn *net.UDPConn
+	remoteAddr *net.UDPAddr
+	order binary.ByteOrder
+	blockSize int
+	timeout time.Duration
+	maxRetries int
+
+	// write side (server RRQ, client WRQ): wbuf accumulates bytes passed to Write until
+	// there's a full block to send; blockNum is the last block sent.
+	wbuf []byte
+	blockNum uint16
+
+	// read side (server WRQ, client RRQ): rbuf holds the payload of the last DATA block
+	// received but not yet fully consumed by Read. pendingAck is the
+	// packet (an ACK, or the initial OACK) to (re)send while waiting for
+	// the next DATA block.
+	rbuf []byte
+	eof bool
+	pendingAck packet.Packet
+
+	// aborted is set once RespondError has sent an ERROR; finishWrite then
+	// sends nothing.
+	aborted bool
+}
+
+// sendPacket serializes pkt and writes it as a single UDP datagram to addr:
+// packet.WritePacket alone issues one underlying Write per field, which
+// would otherwise fragment a packet across several datagrams.
+func sendPacket(conn *net.UDPConn, addr *net.UDPAddr, pkt packet.Packet, order binary.ByteOrder) error {
+	buf := &bytes.Buffer{}
+	if err := packet.WritePacket(buf, pkt, order); err != nil {
+		return err
+	}
+	_, err := conn.WriteToUDP(buf.Bytes(), addr)
+	return err
+}
+
+// exchange (re)sends `send` and waits for a packet from c.remoteAddr that
+// accept reports true for, retransmitting send on each timeout, up to
+// c.maxRetries times. Datagrams from any other address are rfc1350 §4
+// "unknown TID"s: the sender gets an ERROR and is otherwise ignored.
+func (c *conn) exchange(send packet.Packet, accept func(packet.Packet) bool) (packet.Packet, error) {
+	buf := make([]byte, packet.MaxBlockSize+4)
+	for attempt := 0; attempt <= c.maxRetries; attempt++ {
+		if err := sendPacket(c.udpConn, c.remoteAddr, send, c.order); err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(c.timeout)
+		for {
+			c.udpConn.SetReadDeadline(deadline)
+			n, from, err := c.udpConn.ReadFromUDP(buf)
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					break // retransmit send, below
+				}
+				return nil, err
+			}
+			if from.IP.Equal(c.remoteAddr.IP) && from.Port == c.remoteAddr.Port {
+				pkt, _, perr := packet.ReadPacket(bytes.NewReader(buf[:n]), c.blockSize)
+				if perr != nil {
+					continue // malformed; keep listening for this attempt
+				}
+				if accept(pkt) {
+					return pkt, nil
+				}
+				continue
+			}
+			sendPacket(c.udpConn, from, &packet.Error{
+				ErrorCode: packet.ErrUnknownTID,
+				ErrorMsg: "unknown transfer ID",
+			}, c.order)
+		}
+	}
+	return nil, errTimedOut
+}
+
+// Write implements ReadWriter for the sending side of a transfer (the server
+// for an RRQ, the client for a WRQ): it buffers p and emits
+// full blockSize DATA packets as they accumulate, waiting for each one's ACK
+// before sending the next.
+func (c *conn) Write(p []byte) (n int, err error) {
+	c.wbuf = append(c.wbuf, p...)
+	for len(c.wbuf) >= c.blockSize {
+		if err := c.sendBlock(c.wbuf[:c.blockSize]); err != nil {
+			return len(p), err
+		}
+		c.wbuf = c.wbuf[c.blockSize:]
+	}
+	return len(p), nil
+}
+
+// finishWrite flushes whatever remains in wbuf (possibly nothing) as the
+// final DATA block of the transfer, unless RespondError already aborted it.
+func (c *conn) finishWrite() error {
+	if c.aborted {
+		return nil
+	}
+	return c.sendBlock(c.wbuf)
+}
+
+func (c *conn) sendBlock(chunk []byte) error {
+	c.blockNum++
+	want := c.blockNum
+	_, err := c.exchange(&packet.Data{BlockNum: want, Data: chunk}, func(p packet.Packet) bool {
+		a, ok := p.(*packet.Ack)
+		return ok && a.BlockNum == want
+	})
+	return err
+}
+
+// Read implements ReadWriter for the receiving side of a transfer (the server
+// for a WRQ, the client for an RRQ): it receives DATA packets,
+// ACKing each as it arrives, and returns io.EOF once a short (final) block
+// has been delivered.
+func (c *conn) Read(p []byte) (n int, err error) {
+	for len(c.rbuf) == 0 {
+		if c.eof {
+			return 0, io.EOF
+		}
+		if err := c.fillRead(); err != nil {
+			return 0, err
+		}
+	}
+	n = copy(p, c.rbuf)
+	c.rbuf = c.rbuf[n:]
+	return n, nil
+}
+
+func (c *conn) fillRead() error {
+	want := c.blockNum + 1
+	pkt, err := c.exchange(c.pendingAck, func(p packet.Packet) bool {
+		d, ok := p.(*packet.Data)
+		if !ok {
+			return false
+		}
+		if d.BlockNum == want {
+			return true
+		}
+		if d.BlockNum == c.blockNum {
+			// The peer's copy of our last ACK must have been lost; resend
+			// it and keep waiting for the block we haven't seen yet.
+			sendPacket(c.udpConn, c.remoteAddr, c.pendingAck, c.order)
+		}
+		return false
+	})
+	if err != nil {
+		return err
+	}
+	data := pkt.(*packet.Data)
+	c.blockNum = want
+	c.rbuf = data.Data
+	c.pendingAck = &packet.Ack{BlockNum: c.blockNum}
+	if len(data.Data) < c.blockSize {
+		// This is the final block: no further fillRead call will happen to
+		// carry the ack out via exchange's initial send, so send it now.
+		c.eof = true
+		return sendPacket(c.udpConn, c.remoteAddr, c.pendingAck, c.order)
+	}
+	// Otherwise, defer sending: the next fillRead's exchange call sends
+	// c.pendingAck as its first attempt, acking this block while it waits
+	// for the next one.
+	return nil
+}
+
+// RespondError sends a TFTP ERROR packet to the peer, aborting the transfer.
+func (c *conn) RespondError(code int, msg string) {
+	c.aborted = true
+	sendPacket(c.udpConn, c.remoteAddr, &packet.Error{
+		ErrorCode: uint16(code),
+		ErrorMsg: msg,
+	}, c.order)
+}
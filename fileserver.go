@@ -0,0 +1,58 @@
+package tftp
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/zenhack/go.tftp/packet"
+)
+
+// fileHandler serves files out of a root directory, analogous to
+// http.FileServer.
+type fileHandler struct {
+	root string
+}
+
+// FileServer returns a Handler that serves GET (RRQ) and PUT (WRQ) requests
+// against files under root.
+func FileServer(root string) Handler {
+	return fileHandler{root: root}
+}
+
+func (fh fileHandler) path(name string) string {
+	return filepath.Join(fh.root, filepath.Join("/", name))
+}
+
+func (fh fileHandler) ServeTFTP(rw ReadWriter, req *Request) {
+	path := fh.path(req.Filename)
+
+	switch req.Op {
+	case packet.RRQ:
+		f, err := os.Open(path)
+		if err != nil {
+			rw.RespondError(packet.ErrFileNotFound, err.Error())
+			return
+		}
+		defer f.Close()
+		io.Copy(rw, f)
+	case packet.WRQ:
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			rw.RespondError(packet.ErrAccess, err.Error())
+			return
+		}
+		defer f.Close()
+		io.Copy(f, rw)
+	}
+}
+
+// Size implements SizedHandler so a GET's rfc2349 "tsize" option can be
+// answered with the file's real size.
+func (fh fileHandler) Size(req *Request) (int64, error) {
+	info, err := os.Stat(fh.path(req.Filename))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
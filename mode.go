@@ -0,0 +1,151 @@
+package tftp
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"sync"
+)
+
+// TransferMode implements the wire encoding for a TFTP transfer mode
+// (rfc1350 §5). Encode wraps the writer a transfer's raw payload is written
+// to, so mode-specific framing goes out on the wire; Decode wraps the reader
+// of the raw wire payload, undoing that framing before the caller sees it.
+type TransferMode interface {
+	Encode(w io.Writer) io.Writer
+	Decode(r io.Reader) io.Reader
+}
+
+var modesMu sync.RWMutex
+var modes = map[string]TransferMode{
+	"octet":    octetMode{},
+	"netascii": netasciiMode{},
+}
+
+// RegisterMode adds (or replaces) a named transfer mode, e.g. "mail" or a
+// custom mode. Lookups are case-insensitive, per rfc1350 §5. Safe to call
+// concurrently with lookups, including after a Server has started serving.
+func RegisterMode(name string, m TransferMode) {
+	modesMu.Lock()
+	defer modesMu.Unlock()
+	modes[strings.ToLower(name)] = m
+}
+
+// lookupMode returns the registered TransferMode for name (case-insensitive),
+// and false if none is registered.
+func lookupMode(name string) (TransferMode, bool) {
+	modesMu.RLock()
+	defer modesMu.RUnlock()
+	m, ok := modes[strings.ToLower(name)]
+	return m, ok
+}
+
+// modeReadWriter wraps a ReadWriter with a TransferMode's Encode/Decode, so
+// a Handler sees the transfer's decoded payload rather than its wire
+// encoding, while RespondError still goes straight to the underlying
+// ReadWriter.
+type modeReadWriter struct {
+	ReadWriter
+	r io.Reader
+	w io.Writer
+}
+
+func newModeReadWriter(rw ReadWriter, mode TransferMode) *modeReadWriter {
+	return &modeReadWriter{
+		ReadWriter: rw,
+		r:          mode.Decode(rw),
+		w:          mode.Encode(rw),
+	}
+}
+
+func (m *modeReadWriter) Read(p []byte) (int, error)  { return m.r.Read(p) }
+func (m *modeReadWriter) Write(p []byte) (int, error) { return m.w.Write(p) }
+
+// octetMode is the rfc1350 "octet" mode: the payload is transferred as-is.
+type octetMode struct{}
+
+func (octetMode) Encode(w io.Writer) io.Writer { return w }
+func (octetMode) Decode(r io.Reader) io.Reader { return r }
+
+// netasciiMode is the rfc1350 "netascii" mode (rfc1350 §5, rfc764): line
+// endings are translated to the network's CRLF on the wire and back on
+// receipt, and every CR in the host payload is escaped as CR NUL so it can't
+// be confused with a line ending.
+type netasciiMode struct{}
+
+func (netasciiMode) Encode(w io.Writer) io.Writer {
+	return &netasciiEncoder{w: w}
+}
+
+func (netasciiMode) Decode(r io.Reader) io.Reader {
+	return &netasciiDecoder{r: bufio.NewReader(r)}
+}
+
+// netasciiEncoder translates a host payload to netascii on the wire: each
+// '\n' becomes CRLF, and each '\r' becomes CR NUL, including one that is
+// followed by '\n'. Each byte is translated on its own, with no look-ahead,
+// so the output doesn't depend on how the payload is split across Writes.
+type netasciiEncoder struct {
+	w io.Writer
+}
+
+func (e *netasciiEncoder) Write(p []byte) (int, error) {
+	out := make([]byte, 0, len(p))
+	for _, b := range p {
+		switch b {
+		case '\n':
+			out = append(out, '\r', '\n')
+		case '\r':
+			out = append(out, '\r', 0)
+		default:
+			out = append(out, b)
+		}
+	}
+	if _, err := e.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// netasciiDecoder undoes netasciiEncoder's translation: CRLF becomes '\n',
+// and CR NUL becomes a bare '\r'.
+type netasciiDecoder struct {
+	r *bufio.Reader
+}
+
+func (d *netasciiDecoder) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		b, err := d.r.ReadByte()
+		if err != nil {
+			if n > 0 {
+				return n, nil
+			}
+			return n, err
+		}
+		if b != '\r' {
+			p[n] = b
+			n++
+			continue
+		}
+		next, err := d.r.Peek(1)
+		if err != nil {
+			// Bare CR at EOF: pass it through as-is.
+			p[n] = '\r'
+			n++
+			continue
+		}
+		switch next[0] {
+		case '\n':
+			d.r.ReadByte()
+			p[n] = '\n'
+		case 0:
+			d.r.ReadByte()
+			p[n] = '\r'
+		default:
+			p[n] = '\r'
+		}
+		n++
+	}
+	return n, nil
+}
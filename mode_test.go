@@ -0,0 +1,77 @@
+package tftp
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func encodeNetascii(t *testing.T, in []byte) []byte {
+	t.Helper()
+	var mode netasciiMode
+	buf := &bytes.Buffer{}
+	if _, err := mode.Encode(buf).Write(in); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decodeNetascii(t *testing.T, in []byte) []byte {
+	t.Helper()
+	var mode netasciiMode
+	got, err := io.ReadAll(mode.Decode(bytes.NewReader(in)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestNetasciiRoundTrip(t *testing.T) {
+	cases := [][]byte{
+		[]byte("hello, world\n"),
+		[]byte("line one\nline two\nline three"),
+		[]byte("a bare \r in the middle"),
+		[]byte("mixed\r\nand\nlines\r"),
+		{},
+	}
+	for _, want := range cases {
+		wire := encodeNetascii(t, want)
+		got := decodeNetascii(t, wire)
+		if !bytes.Equal(got, want) {
+			t.Fatalf("round trip of %q: got %q", want, got)
+		}
+	}
+}
+
+func TestNetasciiEncodeWireFormat(t *testing.T) {
+	got := encodeNetascii(t, []byte("a\nb\rc"))
+	want := []byte("a\r\nb\r\x00c")
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestLookupModeCaseInsensitive(t *testing.T) {
+	if _, ok := lookupMode("OCTET"); !ok {
+		t.Fatal("want octet mode to be found case-insensitively")
+	}
+	if _, ok := lookupMode("NetASCII"); !ok {
+		t.Fatal("want netascii mode to be found case-insensitively")
+	}
+	if _, ok := lookupMode("bogus"); ok {
+		t.Fatal("want unregistered mode to be absent")
+	}
+}
+
+func TestRegisterMode(t *testing.T) {
+	RegisterMode("mail", octetMode{})
+	defer func() {
+		modesMu.Lock()
+		delete(modes, "mail")
+		modesMu.Unlock()
+	}()
+
+	if _, ok := lookupMode("mail"); !ok {
+		t.Fatal("want registered mode to be found")
+	}
+}
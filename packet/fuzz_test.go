@@ -0,0 +1,20 @@
+package packet
+
+import (
+	"bytes"
+	"testing"
+)
+
+func FuzzReadPacket(f *testing.F) {
+	f.Add([]byte{1, 0, 'a', 0, 'o', 'c', 't', 'e', 't', 0})
+	f.Add([]byte{0, 3, 0, 1})
+	f.Add([]byte{6, 0, 'b', 'l', 'k', 's', 'i', 'z', 'e', 0, '1', '0', '2', '4', 0})
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// ReadPacket must never panic, regardless of how truncated or
+		// malformed the input is; a non-nil error is the expected outcome
+		// for most fuzz-generated inputs.
+		ReadPacket(bytes.NewReader(data), DefaultBlockSize)
+	})
+}
@@ -9,8 +9,9 @@
 package packet
 
 import (
-	"bytes"
+	"bufio"
 	"encoding/binary"
+	"errors"
 	"io"
 )
 
@@ -21,6 +22,18 @@ const (
 	DATA = 3
 	ACK = 4
 	ERROR = 5
+	OACK = 6
+)
+
+const (
+	// DefaultBlockSize is the block size used when a transfer has not
+	// negotiated one via the "blksize" option (rfc2348).
+	DefaultBlockSize = 512
+
+	// MinBlockSize and MaxBlockSize bound the "blksize" option values a
+	// server or client may negotiate, per rfc2348 §2.
+	MinBlockSize = 8
+	MaxBlockSize = 65464
 )
 
 const (
@@ -38,6 +51,11 @@ const (
 type Rq struct {
 	Filename string
 	Mode string
+
+	// Options holds the rfc2347 option/value pairs trailing Mode, keyed by
+	// option name; the order they appeared in on the wire is not kept. It is
+	// nil if the request carried no options.
+	Options map[string]string
 }
 
 type Rrq Rq
@@ -57,28 +75,74 @@ type Error struct {
 	ErrorMsg string
 }
 
+// OAck is the rfc2347 Option Acknowledgement packet (opcode OACK), sent by a
+// server in reply to an Rrq or Wrq to confirm the subset of requested
+// options it will honor.
+type OAck struct {
+	Options map[string]string
+}
+
 // A TFTP packet. All of the methods of this interface are private. The types
-// *Rrq, *Wrq, *Data, *Ack, and *Error implement the Packet interface.
+// *Rrq, *Wrq, *Data, *Ack, *Error, and *OAck implement the Packet interface.
 type Packet interface{
-	readFrom(r io.Reader, order binary.ByteOrder) error
+	readFrom(r *bufio.Reader, order binary.ByteOrder, blockSize int) error
+	writeTo(w io.Writer, order binary.ByteOrder) error
+}
+
+// opcodeOf returns the wire opcode for p.
+func opcodeOf(p Packet) uint16 {
+	switch p.(type) {
+	case *Rrq:
+		return RRQ
+	case *Wrq:
+		return WRQ
+	case *Data:
+		return DATA
+	case *Ack:
+		return ACK
+	case *Error:
+		return ERROR
+	case *OAck:
+		return OACK
+	}
+	panic("packet: unknown Packet type")
+}
+
+// WritePacket writes p to w in the given byte order, opcode first. It is the
+// inverse of ReadPacket: a packet written by WritePacket and then read back
+// with ReadPacket compares equal to the original.
+func WritePacket(w io.Writer, p Packet, order binary.ByteOrder) error {
+	if err := binary.Write(w, order, opcodeOf(p)); err != nil {
+		return err
+	}
+	return p.writeTo(w, order)
 }
 
 // Read a TFTP packet from r, returning the packet, its byte order, and an
 // error. If the error is non-nil, then the packet and byte order are invalid.
 // The byte order is inferred from the opcode of the packet.
-func ReadPacket(r io.Reader) (Packet, binary.ByteOrder, error) {
+//
+// blockSize is the number of bytes a Data packet is expected to carry, as
+// negotiated via the "blksize" option (rfc2348); a value <= 0 means
+// DefaultBlockSize.
+func ReadPacket(r io.Reader, blockSize int) (Packet, binary.ByteOrder, error) {
+	if blockSize <= 0 {
+		blockSize = DefaultBlockSize
+	}
+	br := bufio.NewReader(r)
 
-	// Rfc1350 doesn't say anything about byte order, but we can detect it, since
-	// only opcodes 1 through 5 are valid. We try reading it in as little endian,
-	// and if we get something invalid, we assume we picked the wrong byte order.
+	// Rfc1350 (plus the OACK opcode added by rfc2347) doesn't say anything
+	// about byte order, but we can detect it, since only opcodes 1 through 6
+	// are valid. We try reading it in as little endian, and if we get
+	// something invalid, we assume we picked the wrong byte order.
 	var order binary.ByteOrder
 	order = binary.LittleEndian
 	var Opcode uint16
-	err := binary.Read(r, order, &Opcode)
+	err := binary.Read(br, order, &Opcode)
 	if err != nil {
 		return nil, nil, err
 	}
-	if Opcode > ERROR {
+	if Opcode > OACK {
 		// Wrong endianness; convert it and change the order for later.
 		Opcode = Opcode >> 8
 		order = binary.BigEndian
@@ -97,45 +161,65 @@ func ReadPacket(r io.Reader) (Packet, binary.ByteOrder, error) {
 		ret = &Ack{}
 	case ERROR:
 		ret = &Error{}
+	case OACK:
+		ret = &OAck{}
+	default:
+		return nil, nil, ErrInvalidOpcode
 	}
 
-	err = ret.readFrom(r, order)
+	err = ret.readFrom(br, order, blockSize)
 	return ret, order, err
 }
 
+// ErrInvalidOpcode is returned by ReadPacket when neither byte order yields
+// one of the recognized opcodes.
+var ErrInvalidOpcode = errors.New("packet: invalid opcode")
+
 
-func (rq *Rq) readFrom(r io.Reader, order binary.ByteOrder) error {
+func (rq *Rq) readFrom(r *bufio.Reader, order binary.ByteOrder, blockSize int) error {
 	err := readString(r, &rq.Filename)
 	if err != nil {
 		return err
 	}
 	err = readString(r, &rq.Mode)
+	if err != nil {
+		return err
+	}
+	rq.Options, err = readOptions(r)
 	return err
 }
 
-func (req *Rrq) readFrom(r io.Reader, order binary.ByteOrder) error {
-	return (*Rq)(req).readFrom(r, order)
+func (req *Rrq) readFrom(r *bufio.Reader, order binary.ByteOrder, blockSize int) error {
+	return (*Rq)(req).readFrom(r, order, blockSize)
 }
 
-func (req *Wrq) readFrom(r io.Reader, order binary.ByteOrder) error {
-	return (*Rq)(req).readFrom(r, order)
+func (req *Wrq) readFrom(r *bufio.Reader, order binary.ByteOrder, blockSize int) error {
+	return (*Rq)(req).readFrom(r, order, blockSize)
 }
 
-func (d *Data) readFrom(r io.Reader, order binary.ByteOrder) error {
-	err := binary.Read(r, order, &d.BlockNum)
-	if err != nil {
+// readFrom reads the block number followed by up to blockSize bytes of
+// payload. A short read (the final DATA packet of a transfer is, by
+// definition, the first one smaller than blockSize) is not an error: Data is
+// truncated to the bytes actually read, so callers detect end-of-transfer via
+// len(d.Data) < blockSize.
+func (d *Data) readFrom(r *bufio.Reader, order binary.ByteOrder, blockSize int) error {
+	if err := binary.Read(r, order, &d.BlockNum); err != nil {
 		return err
 	}
-	d.Data = make([]byte, 512)
-	_, err = r.Read(d.Data)
+	buf := make([]byte, blockSize)
+	n, err := io.ReadFull(r, buf)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		err = nil
+	}
+	d.Data = buf[:n]
 	return err
 }
 
-func (a *Ack) readFrom(r io.Reader, order binary.ByteOrder) error {
+func (a *Ack) readFrom(r *bufio.Reader, order binary.ByteOrder, blockSize int) error {
 	return binary.Read(r, order, &a.BlockNum)
 }
 
-func (e *Error) readFrom(r io.Reader, order binary.ByteOrder) error {
+func (e *Error) readFrom(r *bufio.Reader, order binary.ByteOrder, blockSize int) error {
 	err := binary.Read(r, order, &e.ErrorCode)
 	if err != nil {
 		return err
@@ -144,21 +228,100 @@ func (e *Error) readFrom(r io.Reader, order binary.ByteOrder) error {
 	return err
 }
 
-func readString(r io.Reader, s *string) error {
-	buf := bytes.Buffer{}
-	ch := []byte{0}
-	_, err := r.Read(ch)
-	if err != nil {
+func (o *OAck) readFrom(r *bufio.Reader, order binary.ByteOrder, blockSize int) error {
+	var err error
+	o.Options, err = readOptions(r)
+	return err
+}
+
+func (rq *Rq) writeTo(w io.Writer, order binary.ByteOrder) error {
+	if _, err := writeString(rq.Filename, w); err != nil {
 		return err
 	}
-	for ch[0] != 0 {
-		buf.Write(ch)
-		_, err = r.Read(ch)
-		if err != nil {
+	if _, err := writeString(rq.Mode, w); err != nil {
+		return err
+	}
+	return writeOptions(rq.Options, w)
+}
+
+func (req *Rrq) writeTo(w io.Writer, order binary.ByteOrder) error {
+	return (*Rq)(req).writeTo(w, order)
+}
+
+func (req *Wrq) writeTo(w io.Writer, order binary.ByteOrder) error {
+	return (*Rq)(req).writeTo(w, order)
+}
+
+func (d *Data) writeTo(w io.Writer, order binary.ByteOrder) error {
+	if err := binary.Write(w, order, d.BlockNum); err != nil {
+		return err
+	}
+	_, err := w.Write(d.Data)
+	return err
+}
+
+func (a *Ack) writeTo(w io.Writer, order binary.ByteOrder) error {
+	return binary.Write(w, order, a.BlockNum)
+}
+
+func (e *Error) writeTo(w io.Writer, order binary.ByteOrder) error {
+	if err := binary.Write(w, order, e.ErrorCode); err != nil {
+		return err
+	}
+	_, err := writeString(e.ErrorMsg, w)
+	return err
+}
+
+func (o *OAck) writeTo(w io.Writer, order binary.ByteOrder) error {
+	return writeOptions(o.Options, w)
+}
+
+// writeOptions writes opts as a sequence of netascii-terminated key/value
+// pairs. It is a no-op if opts is empty.
+func writeOptions(opts map[string]string, w io.Writer) error {
+	for name, value := range opts {
+		if _, err := writeString(name, w); err != nil {
 			return err
 		}
+		if _, err := writeString(value, w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readOptions reads rfc2347 option/value pairs until r is exhausted. It
+// returns a nil map if no options were present.
+func readOptions(r *bufio.Reader) (map[string]string, error) {
+	var opts map[string]string
+	for {
+		var name string
+		err := readString(r, &name)
+		if err == io.EOF {
+			return opts, nil
+		}
+		if err != nil {
+			return opts, err
+		}
+		var value string
+		if err := readString(r, &value); err != nil {
+			return opts, err
+		}
+		if opts == nil {
+			opts = map[string]string{}
+		}
+		opts[name] = value
+	}
+}
+
+// readString reads a netascii-terminated string (bytes up to and including a
+// NUL) from r, buffering through r rather than issuing a syscall per byte.
+func readString(r *bufio.Reader, s *string) error {
+	str, err := r.ReadString(0)
+	if err != nil {
+		return err
 	}
-	*s = buf.String()
+	*s = str[:len(str)-1]
 	return nil
 }
 
@@ -0,0 +1,146 @@
+package packet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// rawPacket builds the wire bytes for opcode followed by a sequence of
+// netascii-terminated fields, in the given byte order.
+func rawPacket(order binary.ByteOrder, opcode uint16, fields ...string) []byte {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, order, opcode)
+	for _, f := range fields {
+		buf.WriteString(f)
+		buf.WriteByte(0)
+	}
+	return buf.Bytes()
+}
+
+func TestReadRrqWithOptions(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		raw := rawPacket(order, RRQ, "foo.txt", "octet", "blksize", "1024", "tsize", "0")
+		pkt, gotOrder, err := ReadPacket(bytes.NewReader(raw), 0)
+		if err != nil {
+			t.Fatalf("%v: ReadPacket: %v", order, err)
+		}
+		if gotOrder != order {
+			t.Fatalf("%v: got order %v", order, gotOrder)
+		}
+		rrq, ok := pkt.(*Rrq)
+		if !ok {
+			t.Fatalf("%v: got %T, want *Rrq", order, pkt)
+		}
+		if rrq.Filename != "foo.txt" || rrq.Mode != "octet" {
+			t.Fatalf("%v: got %+v", order, rrq)
+		}
+		want := map[string]string{"blksize": "1024", "tsize": "0"}
+		if !reflect.DeepEqual(rrq.Options, want) {
+			t.Fatalf("%v: got options %v, want %v", order, rrq.Options, want)
+		}
+	}
+}
+
+func TestReadWrqWithoutOptions(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		raw := rawPacket(order, WRQ, "foo.txt", "octet")
+		pkt, _, err := ReadPacket(bytes.NewReader(raw), 0)
+		if err != nil {
+			t.Fatalf("%v: ReadPacket: %v", order, err)
+		}
+		wrq, ok := pkt.(*Wrq)
+		if !ok {
+			t.Fatalf("%v: got %T, want *Wrq", order, pkt)
+		}
+		if wrq.Options != nil {
+			t.Fatalf("%v: got options %v, want nil", order, wrq.Options)
+		}
+	}
+}
+
+func TestReadOAck(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		raw := rawPacket(order, OACK, "blksize", "1024", "timeout", "3")
+		pkt, _, err := ReadPacket(bytes.NewReader(raw), 0)
+		if err != nil {
+			t.Fatalf("%v: ReadPacket: %v", order, err)
+		}
+		oack, ok := pkt.(*OAck)
+		if !ok {
+			t.Fatalf("%v: got %T, want *OAck", order, pkt)
+		}
+		want := map[string]string{"blksize": "1024", "timeout": "3"}
+		if !reflect.DeepEqual(oack.Options, want) {
+			t.Fatalf("%v: got options %v, want %v", order, oack.Options, want)
+		}
+	}
+}
+
+func TestReadDataUsesNegotiatedBlockSize(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		buf := &bytes.Buffer{}
+		binary.Write(buf, order, uint16(DATA))
+		binary.Write(buf, order, uint16(1))
+		buf.Write(make([]byte, 1024))
+		pkt, _, err := ReadPacket(bytes.NewReader(buf.Bytes()), 1024)
+		if err != nil {
+			t.Fatalf("%v: ReadPacket: %v", order, err)
+		}
+		data, ok := pkt.(*Data)
+		if !ok {
+			t.Fatalf("%v: got %T, want *Data", order, pkt)
+		}
+		if len(data.Data) != 1024 {
+			t.Fatalf("%v: got %d bytes, want 1024", order, len(data.Data))
+		}
+	}
+}
+
+func TestReadDataShortFinalBlock(t *testing.T) {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, uint16(DATA))
+	binary.Write(buf, binary.LittleEndian, uint16(9))
+	buf.WriteString("tail")
+	pkt, _, err := ReadPacket(bytes.NewReader(buf.Bytes()), 512)
+	if err != nil {
+		t.Fatalf("ReadPacket: %v", err)
+	}
+	data := pkt.(*Data)
+	if string(data.Data) != "tail" {
+		t.Fatalf("got %q, want %q", data.Data, "tail")
+	}
+	if len(data.Data) >= 512 {
+		t.Fatalf("got %d bytes, want < 512 to signal end-of-transfer", len(data.Data))
+	}
+}
+
+func TestReadDataEmptyFinalBlock(t *testing.T) {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, uint16(DATA))
+	binary.Write(buf, binary.LittleEndian, uint16(1))
+	pkt, _, err := ReadPacket(bytes.NewReader(buf.Bytes()), 512)
+	if err != nil {
+		t.Fatalf("ReadPacket: %v", err)
+	}
+	data := pkt.(*Data)
+	if len(data.Data) != 0 {
+		t.Fatalf("got %d bytes, want 0", len(data.Data))
+	}
+}
+
+func TestReadDataDefaultBlockSize(t *testing.T) {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, uint16(DATA))
+	binary.Write(buf, binary.LittleEndian, uint16(1))
+	buf.Write(make([]byte, DefaultBlockSize))
+	pkt, _, err := ReadPacket(bytes.NewReader(buf.Bytes()), 0)
+	if err != nil {
+		t.Fatalf("ReadPacket: %v", err)
+	}
+	data := pkt.(*Data)
+	if len(data.Data) != DefaultBlockSize {
+		t.Fatalf("got %d bytes, want %d", len(data.Data), DefaultBlockSize)
+	}
+}
@@ -0,0 +1,47 @@
+package packet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestWritePacketRoundTrip(t *testing.T) {
+	cases := []struct {
+		name string
+		pkt  Packet
+	}{
+		{"rrq", &Rrq{Filename: "foo.txt", Mode: "octet"}},
+		{"rrq with options", &Rrq{Filename: "foo.txt", Mode: "octet", Options: map[string]string{"blksize": "1024"}}},
+		{"wrq", &Wrq{Filename: "bar.txt", Mode: "netascii"}},
+		{"data", &Data{BlockNum: 7, Data: []byte("hello")}},
+		{"ack", &Ack{BlockNum: 7}},
+		{"error", &Error{ErrorCode: ErrFileNotFound, ErrorMsg: "no such file"}},
+		{"oack", &OAck{Options: map[string]string{"tsize": "0", "timeout": "3"}}},
+	}
+
+	for _, c := range cases {
+		for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+			buf := &bytes.Buffer{}
+			if err := WritePacket(buf, c.pkt, order); err != nil {
+				t.Fatalf("%s/%v: WritePacket: %v", c.name, order, err)
+			}
+
+			blockSize := DefaultBlockSize
+			if d, ok := c.pkt.(*Data); ok {
+				blockSize = len(d.Data)
+			}
+			got, gotOrder, err := ReadPacket(buf, blockSize)
+			if err != nil {
+				t.Fatalf("%s/%v: ReadPacket: %v", c.name, order, err)
+			}
+			if gotOrder != order {
+				t.Fatalf("%s/%v: got order %v", c.name, order, gotOrder)
+			}
+			if !reflect.DeepEqual(got, c.pkt) {
+				t.Fatalf("%s/%v: round trip mismatch: got %+v, want %+v", c.name, order, got, c.pkt)
+			}
+		}
+	}
+}
@@ -1,52 +1,299 @@
+// Package tftp implements an RFC 1350 TFTP server and the scaffolding
+// (rfc2347/2348/2349 option negotiation) needed to plug in file backends.
 package tftp
 
 import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
 	"github.com/zenhack/go.tftp/packet"
 )
 
+const (
+	// DefaultTimeout is how long a connection waits for an ACK/DATA before
+	// retransmitting, absent an explicit Server.Timeout.
+	DefaultTimeout = 5 * time.Second
+
+	// DefaultMaxRetries is how many times a connection retransmits before
+	// giving up on a transfer, absent an explicit Server.MaxRetries.
+	DefaultMaxRetries = 5
+
+	// DefaultAddr is the address Server.ListenAndServe binds when
+	// Server.Addr is empty.
+	DefaultAddr = ":69"
+)
+
+// Request describes an incoming RRQ or WRQ, once option negotiation (rfc2347)
+// has settled which options the server will honor.
+type Request struct {
+	// Op is packet.RRQ for a read (GET) request or packet.WRQ for a write
+	// (PUT) request.
+	Op int
+
+	Filename string
+	Mode string
 
-type GetReq struct {
-	in <-chan packet.Packet
-	out chan<- packet.Packet
-	data []byte
+	// Options holds the subset of the client's requested options
+	// (rfc2347/2348/2349) that the server accepted; nil if the client
+	// requested none, and empty if it requested some but none were accepted.
+	Options map[string]string
+
+	RemoteAddr *net.UDPAddr
 }
 
-type PutReq struct {
-	in <-chan packet.Packet
-	out chan<- packet.Packet
-	data []byte
+// ReadWriter is what a Handler uses to exchange a transfer's payload: Read
+// for a WRQ (the client is uploading), Write for an RRQ (the client is
+// downloading). RespondError aborts the transfer with a TFTP ERROR packet,
+// for use when the Handler can't satisfy the request at all (e.g. a missing
+// file).
+type ReadWriter interface {
+	io.Reader
+	io.Writer
+	RespondError(code int, msg string)
 }
 
-func (r *GetReq) Write(p []byte) (n int, err error) {
+// Handler serves a single negotiated request over rw.
+type Handler interface {
+	ServeTFTP(rw ReadWriter, req *Request)
 }
 
-func (r *PutReq) Read(p []byte) (n int, err error) {
-	goal := len(p)
-	soFar := 0
-	for soFar < goal {
-		if len(r.data) == 0 {
-			pkt := <-r.in
-			dataPkt, ok := pkt.(*packet.Data)
-			if !ok {
-				panic(4)
-			}
-			r.data = dataPkt.Data
-		}
+// SizedHandler is a Handler that can report the size of a GET before serving
+// it, so the server can answer rfc2349's "tsize" option with the real
+// transfer size instead of dropping it. Size is only ever called for an RRQ.
+type SizedHandler interface {
+	Handler
+	Size(req *Request) (int64, error)
+}
+
+// HandlerFunc adapts a plain function to a Handler, mirroring
+// net/http.HandlerFunc.
+type HandlerFunc func(rw ReadWriter, req *Request)
+
+func (f HandlerFunc) ServeTFTP(rw ReadWriter, req *Request) {
+	f(rw, req)
+}
+
+// Server is a TFTP server: it listens for RRQ/WRQ packets on a well-known
+// port and, for each one, opens a fresh ephemeral UDP socket (the server's
+// TID for that transfer) and hands the transfer to Handler.
+type Server struct {
+	// Addr is the UDP address to listen on, e.g. ":69". Defaults to
+	// DefaultAddr.
+	Addr string
+
+	Handler Handler
+
+	// Timeout is how long a transfer waits for a reply before
+	// retransmitting. Defaults to DefaultTimeout.
+	Timeout time.Duration
+
+	// MaxRetries is how many retransmissions a transfer attempts before
+	// aborting. Defaults to DefaultMaxRetries.
+	MaxRetries int
+}
+
+func (s *Server) timeout() time.Duration {
+	if s.Timeout > 0 {
+		return s.Timeout
+	}
+	return DefaultTimeout
+}
+
+func (s *Server) maxRetries() int {
+	if s.MaxRetries > 0 {
+		return s.MaxRetries
 	}
+	return DefaultMaxRetries
 }
 
-func (r *GetReq) RespondError(code int, msg string) {
+// ListenAndServe listens on s.Addr (or DefaultAddr) and serves requests until
+// an error occurs.
+func (s *Server) ListenAndServe() error {
+	addr := s.Addr
+	if addr == "" {
+		addr = DefaultAddr
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(conn)
 }
 
-func (r *PutReq) RespondError(code int, msg string) {
+// Serve accepts RRQ/WRQ packets on conn, spawning a goroutine per transfer,
+// until ReadFromUDP returns an error (e.g. because conn was closed).
+func (s *Server) Serve(conn *net.UDPConn) error {
+	defer conn.Close()
+	buf := make([]byte, packet.MaxBlockSize+4)
+	for {
+		n, remoteAddr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return err
+		}
+		pkt, order, err := packet.ReadPacket(bytes.NewReader(buf[:n]), packet.DefaultBlockSize)
+		if err != nil {
+			// Malformed request on the well-known port; nothing to reply
+			// to yet (we don't have a TID), so just drop it.
+			continue
+		}
+		switch p := pkt.(type) {
+		case *packet.Rrq:
+			go s.handleRequest(packet.RRQ, (*packet.Rq)(p), order, remoteAddr)
+		case *packet.Wrq:
+			go s.handleRequest(packet.WRQ, (*packet.Rq)(p), order, remoteAddr)
+		}
+	}
 }
 
-func handleClient(in <-chan packet.Packet, out chan<- packet.Packet) {
-	pkt := <-in
-	switch p := pkt.(type) {
-	case *packet.Rrq:
-	case *packet.Wrq:
-		handleWrite(p)
+// handleRequest runs an entire transfer: it opens the per-transfer TID
+// socket, negotiates options, and hands off to s.Handler.
+func (s *Server) handleRequest(op int, rq *packet.Rq, order binary.ByteOrder, remoteAddr *net.UDPAddr) {
+	tidConn, err := net.ListenUDP("udp", &net.UDPAddr{Port: 0})
+	if err != nil {
+		return
+	}
+	defer tidConn.Close()
+
+	handler := s.Handler
+
+	accepted := negotiateOptions(rq.Options)
+	blockSize := packet.DefaultBlockSize
+	if v, ok := accepted["blksize"]; ok {
+		if n, err := strconv.Atoi(v); err == nil {
+			blockSize = n
+		}
+	}
+	timeout := s.timeout()
+	if v, ok := accepted["timeout"]; ok {
+		if n, err := strconv.Atoi(v); err == nil {
+			timeout = time.Duration(n) * time.Second
+		}
+	}
+	negotiateTsize(accepted, handler, op, rq, remoteAddr)
+
+	c := &conn{
+		udpConn: tidConn,
+		remoteAddr: remoteAddr,
+		order: order,
+		blockSize: blockSize,
+		timeout: timeout,
+		maxRetries: s.maxRetries(),
+	}
+
+	mode, ok := lookupMode(rq.Mode)
+	if !ok {
+		c.RespondError(packet.ErrIllegalOp, "unsupported transfer mode: "+rq.Mode)
+		return
+	}
+
+	switch op {
+	case packet.RRQ:
+		if len(accepted) > 0 {
+			// rfc2347: for a read request, the client must explicitly ACK
+			// the OACK (as if it were DATA block 0) before we send block 1.
+			_, err := c.exchange(&packet.OAck{Options: accepted}, func(p packet.Packet) bool {
+				a, ok := p.(*packet.Ack)
+				return ok && a.BlockNum == 0
+			})
+			if err != nil {
+				return
+			}
+		}
+	case packet.WRQ:
+		if len(accepted) > 0 {
+			// rfc2347: for a write request, the OACK itself stands in for
+			// ACK 0 — the client's reply is DATA block 1, handled by the
+			// normal Read path below.
+			c.pendingAck = &packet.OAck{Options: accepted}
+		} else {
+			c.pendingAck = &packet.Ack{BlockNum: 0}
+		}
 	default:
+		return
+	}
+
+	if handler == nil {
+		c.RespondError(packet.ErrNotDefined, "no handler configured")
+		return
+	}
+
+	req := &Request{
+		Op: op,
+		Filename: rq.Filename,
+		Mode: rq.Mode,
+		Options: accepted,
+		RemoteAddr: remoteAddr,
+	}
+	handler.ServeTFTP(newModeReadWriter(c, mode), req)
+
+	if op == packet.RRQ {
+		// Flush whatever's left in the write buffer as the final (possibly
+		// zero-length) DATA block, per rfc1350 §2.
+		c.finishWrite()
+	}
+}
+
+// negotiateOptions implements the server side of rfc2347/2348/2349 option
+// negotiation: requested options the server doesn't recognize or can't
+// satisfy are silently dropped rather than rejected.
+func negotiateOptions(requested map[string]string) map[string]string {
+	if len(requested) == 0 {
+		return nil
+	}
+	accepted := map[string]string{}
+	if v, ok := requested["blksize"]; ok {
+		if n, err := strconv.Atoi(v); err == nil && n >= packet.MinBlockSize && n <= packet.MaxBlockSize {
+			accepted["blksize"] = strconv.Itoa(n)
+		}
+	}
+	if v, ok := requested["timeout"]; ok {
+		if n, err := strconv.Atoi(v); err == nil && n >= 1 && n <= 255 {
+			accepted["timeout"] = strconv.Itoa(n)
+		}
+	}
+	return accepted
+}
+
+// negotiateTsize implements the rfc2349 "tsize" option: for a WRQ, the
+// client's declared size is echoed back verbatim (the server doesn't
+// pre-validate available disk space); for an RRQ, only a SizedHandler can
+// say how big the reply will actually be, so the option is dropped rather
+// than answered with a value that isn't real. On success it sets
+// accepted["tsize"].
+func negotiateTsize(accepted map[string]string, handler Handler, op int, rq *packet.Rq, remoteAddr *net.UDPAddr) {
+	v, ok := rq.Options["tsize"]
+	if !ok {
+		return
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return
+	}
+	switch op {
+	case packet.WRQ:
+		accepted["tsize"] = strconv.FormatInt(n, 10)
+	case packet.RRQ:
+		sh, ok := handler.(SizedHandler)
+		if !ok {
+			return
+		}
+		size, err := sh.Size(&Request{
+			Op: op,
+			Filename: rq.Filename,
+			Mode: rq.Mode,
+			RemoteAddr: remoteAddr,
+		})
+		if err != nil {
+			return
+		}
+		accepted["tsize"] = strconv.FormatInt(size, 10)
 	}
 }
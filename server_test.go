@@ -0,0 +1,266 @@
+package tftp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/zenhack/go.tftp/packet"
+)
+
+// startTestServer spins up a real Server on loopback, serving root, and
+// returns its address.
+func startTestServer(t *testing.T, root string) *net.UDPAddr {
+	t.Helper()
+	udpAddr, err := net.ResolveUDPAddr("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{
+		Handler: FileServer(root),
+		Timeout: 200 * time.Millisecond,
+		MaxRetries: 3,
+	}
+	go srv.Serve(conn)
+	t.Cleanup(func() { conn.Close() })
+	return conn.LocalAddr().(*net.UDPAddr)
+}
+
+// testClient is a minimal, independent rfc1350 client used to exercise the
+// server over real UDP loopback, standing in for a third-party client: it
+// only knows the wire protocol via the packet package, not this package's
+// internals.
+type testClient struct {
+	conn *net.UDPConn
+	serverAddr *net.UDPAddr
+}
+
+func dialTestClient(t *testing.T, serverAddr *net.UDPAddr) *testClient {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{conn: conn, serverAddr: serverAddr}
+}
+
+func (c *testClient) send(p packet.Packet, to *net.UDPAddr) {
+	buf := &bytes.Buffer{}
+	packet.WritePacket(buf, p, binary.LittleEndian)
+	c.conn.WriteToUDP(buf.Bytes(), to)
+}
+
+func (c *testClient) recv(t *testing.T, blockSize int) (packet.Packet, *net.UDPAddr) {
+	t.Helper()
+	buf := make([]byte, packet.MaxBlockSize+4)
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, from, err := c.conn.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("recv: %v", err)
+	}
+	pkt, _, err := packet.ReadPacket(bytes.NewReader(buf[:n]), blockSize)
+	if err != nil {
+		t.Fatalf("recv: ReadPacket: %v", err)
+	}
+	return pkt, from
+}
+
+func (c *testClient) get(t *testing.T, filename string) []byte {
+	t.Helper()
+	c.send(&packet.Rrq{Filename: filename, Mode: "octet"}, c.serverAddr)
+
+	var result []byte
+	var tid *net.UDPAddr
+	var blockNum uint16
+	for {
+		pkt, from := c.recv(t, packet.DefaultBlockSize)
+		if tid == nil {
+			tid = from
+		}
+		data, ok := pkt.(*packet.Data)
+		if !ok {
+			t.Fatalf("get %s: got %T, want *Data", filename, pkt)
+		}
+		blockNum++
+		if data.BlockNum != blockNum {
+			t.Fatalf("get %s: got block %d, want %d", filename, data.BlockNum, blockNum)
+		}
+		result = append(result, data.Data...)
+		c.send(&packet.Ack{BlockNum: blockNum}, tid)
+		if len(data.Data) < packet.DefaultBlockSize {
+			break
+		}
+	}
+	return result
+}
+
+func (c *testClient) put(t *testing.T, filename string, data []byte) {
+	t.Helper()
+	c.send(&packet.Wrq{Filename: filename, Mode: "octet"}, c.serverAddr)
+
+	pkt, tid := c.recv(t, packet.DefaultBlockSize)
+	ack, ok := pkt.(*packet.Ack)
+	if !ok || ack.BlockNum != 0 {
+		t.Fatalf("put %s: got %+v, want ACK 0", filename, pkt)
+	}
+
+	var blockNum uint16
+	for {
+		start := int(blockNum) * packet.DefaultBlockSize
+		stop := start + packet.DefaultBlockSize
+		if stop > len(data) {
+			stop = len(data)
+		}
+		chunk := data[start:stop]
+		blockNum++
+		c.send(&packet.Data{BlockNum: blockNum, Data: chunk}, tid)
+
+		pkt, _ := c.recv(t, packet.DefaultBlockSize)
+		ack, ok := pkt.(*packet.Ack)
+		if !ok || ack.BlockNum != blockNum {
+			t.Fatalf("put %s: got %+v, want ACK %d", filename, pkt, blockNum)
+		}
+		if len(chunk) < packet.DefaultBlockSize {
+			break
+		}
+	}
+}
+
+func TestServerGet(t *testing.T) {
+	dir := t.TempDir()
+	want := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog. "), 50)
+	if err := os.WriteFile(filepath.Join(dir, "foo.txt"), want, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	addr := startTestServer(t, dir)
+	client := dialTestClient(t, addr)
+
+	got := client.get(t, "foo.txt")
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got %d bytes, want %d", len(got), len(want))
+	}
+}
+
+func TestServerGetShorterThanOneBlock(t *testing.T) {
+	dir := t.TempDir()
+	want := []byte("hello, tftp")
+	if err := os.WriteFile(filepath.Join(dir, "small.txt"), want, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	addr := startTestServer(t, dir)
+	client := dialTestClient(t, addr)
+
+	got := client.get(t, "small.txt")
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestServerGetMissingFile(t *testing.T) {
+	dir := t.TempDir()
+	addr := startTestServer(t, dir)
+	client := dialTestClient(t, addr)
+
+	client.send(&packet.Rrq{Filename: "nope.txt", Mode: "octet"}, client.serverAddr)
+	pkt, _ := client.recv(t, packet.DefaultBlockSize)
+	errPkt, ok := pkt.(*packet.Error)
+	if !ok {
+		t.Fatalf("got %T, want *Error", pkt)
+	}
+	if errPkt.ErrorCode != packet.ErrFileNotFound {
+		t.Fatalf("got error code %d, want %d", errPkt.ErrorCode, packet.ErrFileNotFound)
+	}
+}
+
+func TestServerGetUnsupportedMode(t *testing.T) {
+	dir := t.TempDir()
+	addr := startTestServer(t, dir)
+	client := dialTestClient(t, addr)
+
+	client.send(&packet.Rrq{Filename: "foo.txt", Mode: "mail"}, client.serverAddr)
+	pkt, _ := client.recv(t, packet.DefaultBlockSize)
+	errPkt, ok := pkt.(*packet.Error)
+	if !ok {
+		t.Fatalf("got %T, want *Error", pkt)
+	}
+	if errPkt.ErrorCode != packet.ErrIllegalOp {
+		t.Fatalf("got error code %d, want %d", errPkt.ErrorCode, packet.ErrIllegalOp)
+	}
+}
+
+func TestServerPut(t *testing.T) {
+	dir := t.TempDir()
+	addr := startTestServer(t, dir)
+	client := dialTestClient(t, addr)
+
+	want := bytes.Repeat([]byte("uploaded data, block after block. "), 60)
+	client.put(t, "uploaded.txt", want)
+
+	got, err := os.ReadFile(filepath.Join(dir, "uploaded.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got %d bytes on disk, want %d", len(got), len(want))
+	}
+}
+
+func TestNegotiateOptionsTimeout(t *testing.T) {
+	cases := []struct {
+		requested string
+		want      string
+		wantOK    bool
+	}{
+		{"10", "10", true},
+		{"255", "255", true},
+		{"0", "", false},
+		{"256", "", false},
+		{"nope", "", false},
+	}
+	for _, c := range cases {
+		accepted := negotiateOptions(map[string]string{"timeout": c.requested})
+		v, ok := accepted["timeout"]
+		if ok != c.wantOK || v != c.want {
+			t.Fatalf("negotiateOptions(timeout=%q): got (%q, %v), want (%q, %v)", c.requested, v, ok, c.want, c.wantOK)
+		}
+	}
+}
+
+func TestNegotiateTsize(t *testing.T) {
+	dir := t.TempDir()
+	want := []byte("hello, tsize")
+	if err := os.WriteFile(filepath.Join(dir, "foo.txt"), want, 0644); err != nil {
+		t.Fatal(err)
+	}
+	handler := FileServer(dir)
+
+	accepted := map[string]string{}
+	negotiateTsize(accepted, handler, packet.RRQ, &packet.Rq{Filename: "foo.txt", Options: map[string]string{"tsize": "0"}}, nil)
+	if got := accepted["tsize"]; got != strconv.Itoa(len(want)) {
+		t.Fatalf("RRQ tsize: got %q, want %q", got, strconv.Itoa(len(want)))
+	}
+
+	accepted = map[string]string{}
+	negotiateTsize(accepted, handler, packet.RRQ, &packet.Rq{Filename: "nope.txt", Options: map[string]string{"tsize": "0"}}, nil)
+	if _, ok := accepted["tsize"]; ok {
+		t.Fatalf("RRQ tsize for missing file: got accepted, want dropped")
+	}
+
+	accepted = map[string]string{}
+	negotiateTsize(accepted, handler, packet.WRQ, &packet.Rq{Filename: "new.txt", Options: map[string]string{"tsize": "42"}}, nil)
+	if got := accepted["tsize"]; got != "42" {
+		t.Fatalf("WRQ tsize: got %q, want %q", got, "42")
+	}
+}